@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -17,11 +18,11 @@ func main() {
 		Transport: transport,
 	}
 	list, _ := client.Search(
+		context.Background(),
 		"test_data_*",
 		"{\"match_all\": {}}",
 		&[]string{"*"},
-		"id",
-		true,
+		[]esdeeppager.SortField{{Field: "id", Type: esdeeppager.Long, Asc: true}},
 		100000000,
 		10000,
 	)
@@ -0,0 +1,309 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// backend does the actual count/search/PIT calls DeepPageClient's binary-search and batch-walk
+// algorithm is built on, so that algorithm can run over either the low-level elastic-transport-go
+// client (transportBackend, DeepPageClient's original and still default implementation strategy)
+// or the official typed client (typedBackend), without being duplicated for each.
+type backend interface {
+	// count returns the total number of documents matching query.
+	count(ctx context.Context, index string, query string) (int64, error)
+
+	// search runs query against index and returns its hits. When searchAfter is not nil, it is
+	// sent verbatim as the request's search_after and from is ignored, per the searchAPI's rules.
+	search(ctx context.Context, index string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error)
+
+	// openPIT opens a Point In Time on index, kept alive for keepAlive (e.g. "1m"), and returns its id.
+	openPIT(ctx context.Context, index string, keepAlive string) (string, error)
+
+	// closePIT closes a Point In Time previously opened by openPIT.
+	closePIT(ctx context.Context, pitId string) error
+
+	// countPIT returns the total number of documents matching query within the pitId snapshot.
+	countPIT(ctx context.Context, pitId string, query string) (int64, error)
+
+	// searchPIT behaves like search, but runs within the pitId snapshot.
+	searchPIT(ctx context.Context, pitId string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error)
+}
+
+// backend returns the backend this client should run its calls through: a typedBackend wrapping
+// client.es if the client was built with NewFromESClient, otherwise a transportBackend wrapping
+// client.Transport, DeepPageClient's original and default implementation strategy.
+func (client *DeepPageClient) backend() backend {
+	if client.es != nil {
+		return &typedBackend{es: client.es}
+	}
+	return &transportBackend{client: client}
+}
+
+// buildSearchBody renders a searchAPI request body for query, an optional pitId (empty for a plain,
+// non-PIT search), the given source filter and sort, and either from or searchAfter (mutually
+// exclusive, per the searchAPI's rules).
+func buildSearchBody(query string, pitId string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (string, error) {
+	sortJson, err := sortClause(sort)
+	if err != nil {
+		return "", err
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("{")
+	builder.WriteString("\"query\": " + query + ",")
+	if pitId != "" {
+		builder.WriteString("\"pit\": {\"id\":\"" + pitId + "\",\"keep_alive\":\"" + defaultPitKeepAlive + "\"},")
+	}
+	builder.WriteString("\"sort\": " + sortJson + ",")
+	if source != nil {
+		sourceStr := make([]string, 0)
+		for _, value := range *source {
+			sourceStr = append(sourceStr, "\""+value+"\"")
+		}
+		builder.WriteString("\"_source\": " + "[" + strings.Join(sourceStr, ",") + "],")
+	}
+	if searchAfter != nil {
+		afterJson, err := json.Marshal(searchAfter)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString("\"search_after\": " + string(afterJson) + ",")
+	} else {
+		builder.WriteString("\"from\":" + strconv.FormatInt(from, 10) + ",")
+	}
+	builder.WriteString("\"size\":" + strconv.FormatInt(size, 10))
+	builder.WriteString("}")
+	return builder.String(), nil
+}
+
+// buildCountPITBody renders a searchAPI request body that counts the documents matching query
+// within the pitId snapshot, without returning any hits.
+func buildCountPITBody(query string, pitId string) string {
+	builder := strings.Builder{}
+	builder.WriteString("{")
+	builder.WriteString("\"query\": " + query + ",")
+	builder.WriteString("\"pit\": {\"id\":\"" + pitId + "\",\"keep_alive\":\"" + defaultPitKeepAlive + "\"},")
+	builder.WriteString("\"track_total_hits\": true,")
+	builder.WriteString("\"size\": 0")
+	builder.WriteString("}")
+	return builder.String()
+}
+
+// parseHits decodes a searchAPI JSON response and returns its hits as a slice of maps.
+func parseHits(resp string) (*[]map[string]any, error) {
+	var result map[string]any
+	json.Unmarshal([]byte(resp), &result)
+
+	var hits []map[string]any
+	for _, value := range (result["hits"].(map[string]any))["hits"].([]any) {
+		hits = append(hits, value.(map[string]any))
+	}
+	return &hits, nil
+}
+
+// transportBackend is the backend that talks to Elasticsearch over the low-level
+// elastic-transport-go client, sending hand-rolled JSON request bodies. This is DeepPageClient's
+// original implementation strategy, still used by default when it is constructed via its
+// Transport field instead of NewFromESClient.
+type transportBackend struct {
+	client *DeepPageClient
+}
+
+func (b *transportBackend) count(ctx context.Context, index string, query string) (int64, error) {
+	body := "{\"query\": " + query + "}"
+	resp, err := b.client.postJson(ctx, index+"/_count", body)
+	if err != nil {
+		return 0, err
+	}
+
+	var result map[string]any
+	json.Unmarshal([]byte(resp), &result)
+	return int64(result["count"].(float64)), nil
+}
+
+func (b *transportBackend) search(ctx context.Context, index string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	body, err := buildSearchBody(query, "", source, sort, from, size, searchAfter)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.postJson(ctx, index+"/_search", body)
+	if err != nil {
+		return nil, err
+	}
+	return parseHits(resp)
+}
+
+func (b *transportBackend) openPIT(ctx context.Context, index string, keepAlive string) (string, error) {
+	resp, err := b.client.postJson(ctx, index+"/_pit?keep_alive="+keepAlive, "")
+	if err != nil {
+		return "", err
+	}
+
+	var result map[string]any
+	json.Unmarshal([]byte(resp), &result)
+
+	pitId, _ := result["id"].(string)
+	if pitId == "" {
+		return "", errors.New("failed to open point in time")
+	}
+	return pitId, nil
+}
+
+func (b *transportBackend) closePIT(ctx context.Context, pitId string) error {
+	body := "{\"id\":\"" + pitId + "\"}"
+	_, err := b.client.deleteJson(ctx, "/_pit", body)
+	return err
+}
+
+func (b *transportBackend) countPIT(ctx context.Context, pitId string, query string) (int64, error) {
+	resp, err := b.client.postJson(ctx, "/_search", buildCountPITBody(query, pitId))
+	if err != nil {
+		return 0, err
+	}
+
+	var result map[string]any
+	json.Unmarshal([]byte(resp), &result)
+
+	total := (result["hits"].(map[string]any))["total"].(map[string]any)
+	return int64(total["value"].(float64)), nil
+}
+
+func (b *transportBackend) searchPIT(ctx context.Context, pitId string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	body, err := buildSearchBody(query, pitId, source, sort, from, size, searchAfter)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.postJson(ctx, "/_search", body)
+	if err != nil {
+		return nil, err
+	}
+	return parseHits(resp)
+}
+
+// typedBackend is the backend that talks to Elasticsearch over a *elasticsearch.TypedClient,
+// constructed with NewFromESClient. It sends the same hand-rolled JSON bodies as transportBackend
+// through the Count and Search endpoints' Raw escape hatch (opening and closing a PIT go through
+// the typed OpenPointInTime/ClosePointInTime builders), and converts typed Hit results back into the
+// map[string]any{"_source": ..., "sort": ...} shape the search algorithm expects, so users already
+// depending on go-elasticsearch/v8 don't have to construct a second, separate transport.
+//
+// Unlike transportBackend, its calls go straight to es's Do(ctx) and are not routed through
+// DeepPageClient's Retrier: es already retries internally per its own MaxRetries/RetryOnStatus
+// configuration, and there is no clean way to intercept that to also run a Retrier, since Do
+// returns a decoded Response rather than the *http.Response Retrier expects.
+type typedBackend struct {
+	es *elasticsearch.TypedClient
+}
+
+func (b *typedBackend) count(ctx context.Context, index string, query string) (int64, error) {
+	body := "{\"query\": " + query + "}"
+	resp, err := b.es.Count().Index(index).Raw(strings.NewReader(body)).Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func (b *typedBackend) search(ctx context.Context, index string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	body, err := buildSearchBody(query, "", source, sort, from, size, searchAfter)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.es.Search().Index(index).Raw(strings.NewReader(body)).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hitsToMaps(resp.Hits.Hits)
+}
+
+func (b *typedBackend) openPIT(ctx context.Context, index string, keepAlive string) (string, error) {
+	resp, err := b.es.OpenPointInTime(index).KeepAlive(keepAlive).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if resp.Id == "" {
+		return "", errors.New("failed to open point in time")
+	}
+	return resp.Id, nil
+}
+
+func (b *typedBackend) closePIT(ctx context.Context, pitId string) error {
+	_, err := b.es.ClosePointInTime().Id(pitId).Do(ctx)
+	return err
+}
+
+func (b *typedBackend) countPIT(ctx context.Context, pitId string, query string) (int64, error) {
+	body := buildCountPITBody(query, pitId)
+	resp, err := b.es.Search().Raw(strings.NewReader(body)).Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Hits.Total == nil {
+		return 0, nil
+	}
+	return resp.Hits.Total.Value, nil
+}
+
+func (b *typedBackend) searchPIT(ctx context.Context, pitId string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	body, err := buildSearchBody(query, pitId, source, sort, from, size, searchAfter)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.es.Search().Raw(strings.NewReader(body)).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hitsToMaps(resp.Hits.Hits)
+}
+
+// hitsToMaps converts typed Hit results into the map[string]any{"_source": ..., "sort": ...} shape
+// DeepPageClient's search algorithm expects, the same shape decoding the raw searchAPI JSON response
+// produces.
+func hitsToMaps(hits []types.Hit) (*[]map[string]any, error) {
+	result := make([]map[string]any, 0, len(hits))
+	for _, hit := range hits {
+		var source any
+		if len(hit.Source_) > 0 {
+			if err := json.Unmarshal(hit.Source_, &source); err != nil {
+				return nil, err
+			}
+		}
+		sortValues := make([]any, len(hit.Sort))
+		for i, value := range hit.Sort {
+			sortValues[i] = value
+		}
+		result = append(result, map[string]any{"_source": source, "sort": sortValues})
+	}
+	return &result, nil
+}
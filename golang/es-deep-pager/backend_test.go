@@ -0,0 +1,160 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+func TestBuildSearchBodyWithFrom(t *testing.T) {
+	body, err := buildSearchBody(`{"match_all":{}}`, "", nil, []SortField{{Field: "id", Type: Long, Asc: true}}, 10, 20, nil)
+	if err != nil {
+		t.Fatalf("buildSearchBody returned error: %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("buildSearchBody produced invalid JSON: %v", err)
+	}
+	if decoded["from"] != float64(10) {
+		t.Errorf("from = %v, want 10", decoded["from"])
+	}
+	if decoded["size"] != float64(20) {
+		t.Errorf("size = %v, want 20", decoded["size"])
+	}
+	if _, ok := decoded["search_after"]; ok {
+		t.Error("search_after should be absent when searchAfter is nil")
+	}
+	if _, ok := decoded["pit"]; ok {
+		t.Error("pit should be absent when pitId is empty")
+	}
+}
+
+func TestBuildSearchBodyWithSearchAfterAndPIT(t *testing.T) {
+	body, err := buildSearchBody(`{"match_all":{}}`, "pit-id", &[]string{"field1"}, []SortField{{Field: "id", Type: Long, Asc: true}}, 0, 20, []any{"a", 1})
+	if err != nil {
+		t.Fatalf("buildSearchBody returned error: %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("buildSearchBody produced invalid JSON: %v", err)
+	}
+	if _, ok := decoded["from"]; ok {
+		t.Error("from should be absent when searchAfter is set")
+	}
+	searchAfter, ok := decoded["search_after"].([]any)
+	if !ok || len(searchAfter) != 2 {
+		t.Errorf("search_after = %v, want [\"a\", 1]", decoded["search_after"])
+	}
+	pit, ok := decoded["pit"].(map[string]any)
+	if !ok || pit["id"] != "pit-id" {
+		t.Errorf("pit = %v, want id \"pit-id\"", decoded["pit"])
+	}
+	source, ok := decoded["_source"].([]any)
+	if !ok || len(source) != 1 || source[0] != "field1" {
+		t.Errorf("_source = %v, want [\"field1\"]", decoded["_source"])
+	}
+}
+
+func TestBuildCountPITBody(t *testing.T) {
+	body := buildCountPITBody(`{"match_all":{}}`, "pit-id")
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("buildCountPITBody produced invalid JSON: %v", err)
+	}
+	if decoded["size"] != float64(0) {
+		t.Errorf("size = %v, want 0", decoded["size"])
+	}
+	pit, ok := decoded["pit"].(map[string]any)
+	if !ok || pit["id"] != "pit-id" {
+		t.Errorf("pit = %v, want id \"pit-id\"", decoded["pit"])
+	}
+}
+
+func TestParseHits(t *testing.T) {
+	resp := `{"hits":{"hits":[{"_source":{"id":1},"sort":[1]},{"_source":{"id":2},"sort":[2]}]}}`
+	hits, err := parseHits(resp)
+	if err != nil {
+		t.Fatalf("parseHits returned error: %v", err)
+	}
+	if len(*hits) != 2 {
+		t.Fatalf("len(hits) = %d, want 2", len(*hits))
+	}
+	if (*hits)[0]["_source"].(map[string]any)["id"] != float64(1) {
+		t.Errorf("hits[0]._source.id = %v, want 1", (*hits)[0]["_source"])
+	}
+}
+
+func TestHitsToMaps(t *testing.T) {
+	hits := []types.Hit{
+		{Source_: json.RawMessage(`{"id":1}`), Sort: []types.FieldValue{1}},
+		{Source_: json.RawMessage(`{"id":2}`), Sort: []types.FieldValue{2}},
+	}
+
+	maps, err := hitsToMaps(hits)
+	if err != nil {
+		t.Fatalf("hitsToMaps returned error: %v", err)
+	}
+	if len(*maps) != 2 {
+		t.Fatalf("len(maps) = %d, want 2", len(*maps))
+	}
+
+	source, ok := (*maps)[0]["_source"].(map[string]any)
+	if !ok || source["id"] != float64(1) {
+		t.Errorf("maps[0]._source = %v, want {id: 1}", (*maps)[0]["_source"])
+	}
+	sortValues, ok := (*maps)[0]["sort"].([]any)
+	if !ok || len(sortValues) != 1 {
+		t.Errorf("maps[0].sort = %v, want [1]", (*maps)[0]["sort"])
+	}
+}
+
+func TestHitsToMapsEmptySource(t *testing.T) {
+	hits := []types.Hit{{Sort: []types.FieldValue{1}}}
+
+	maps, err := hitsToMaps(hits)
+	if err != nil {
+		t.Fatalf("hitsToMaps returned error: %v", err)
+	}
+	if (*maps)[0]["_source"] != nil {
+		t.Errorf("_source = %v, want nil for a hit with no source", (*maps)[0]["_source"])
+	}
+}
+
+func TestBuildSearchBodySourceOrdering(t *testing.T) {
+	body, err := buildSearchBody(`{"match_all":{}}`, "", &[]string{"a", "b"}, []SortField{{Field: "id", Type: Long, Asc: true}}, 0, 10, nil)
+	if err != nil {
+		t.Fatalf("buildSearchBody returned error: %v", err)
+	}
+	if !strings.Contains(body, `"_source": ["a","b"]`) {
+		t.Errorf("body = %s, want _source to preserve field order", body)
+	}
+}
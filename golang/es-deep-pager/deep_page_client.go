@@ -25,15 +25,20 @@ SOFTWARE.
 package esdeeppager
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"wantalgh/es-deep-pager/dsl"
 )
 
 // DeepPageClient is a client for the Elasticsearch deep page search API.
@@ -48,13 +53,118 @@ type DeepPageClient struct {
 	// elasticsearch low level transport client
 	// Reference: https://github.com/elastic/elastic-transport-go
 	Transport *elastictransport.Client
+
+	// Retrier decides whether and how long to wait before retrying a failed HTTP call. If nil,
+	// DefaultRetrier is used. It can also be overridden for a single call with WithRetrier.
+	// Only used on the Transport path; a client built with NewFromESClient ignores it, see that
+	// constructor's doc comment.
+	Retrier Retrier
+
+	// es is the typed client a DeepPageClient built with NewFromESClient runs its calls through,
+	// instead of Transport. Left nil by the zero-value/Transport-based construction this type has
+	// always supported.
+	es *elasticsearch.TypedClient
+}
+
+// NewFromESClient returns a DeepPageClient that runs its calls through es, the official typed
+// client, instead of through the Transport field. Use this constructor instead of building a
+// DeepPageClient{Transport: ...} when the caller already depends on github.com/elastic/go-elasticsearch/v8
+// and would otherwise have to construct a second, separate transport for this package.
+//
+// Retry/backoff for this path is delegated entirely to es's own configuration (its MaxRetries and
+// RetryOnStatus); the returned client's Retrier field and WithRetrier option are ignored, since es
+// already owns the retry loop around every request it sends.
+func NewFromESClient(es *elasticsearch.TypedClient) *DeepPageClient {
+	return &DeepPageClient{es: es}
 }
 
 const (
 	maxFrom = 2000
 	maxSize = 3000
+
+	// defaultPitKeepAlive is how long a Point In Time opened by SearchPIT is kept alive between
+	// the requests of a single deep page walk.
+	defaultPitKeepAlive = "1m"
 )
 
+// ResultIterator streams the hits of a SearchIter call one at a time, instead of buffering the
+// whole page in memory. It is not safe for concurrent use by multiple goroutines.
+type ResultIterator struct {
+	hits      chan map[string]any
+	errc      chan error
+	cancel    chan struct{}
+	closeOnce sync.Once
+}
+
+func newResultIterator() *ResultIterator {
+	return &ResultIterator{
+		hits:   make(chan map[string]any),
+		errc:   make(chan error, 1),
+		cancel: make(chan struct{}),
+	}
+}
+
+// Next returns the next hit, or io.EOF once the search is exhausted. It blocks until a hit is
+// decoded, the search fails, the search is exhausted, or ctx is done.
+func (it *ResultIterator) Next(ctx context.Context) (map[string]any, error) {
+	select {
+	case hit, ok := <-it.hits:
+		if !ok {
+			select {
+			case err := <-it.errc:
+				return nil, err
+			default:
+				return nil, io.EOF
+			}
+		}
+		return hit, nil
+	case err := <-it.errc:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops the background query, if it is still running, and releases its resources. It is
+// safe to call more than once, and safe to call before the iterator is exhausted.
+func (it *ResultIterator) Close() {
+	it.closeOnce.Do(func() {
+		close(it.cancel)
+	})
+}
+
+// Query is implemented by the query builders in the wantalgh/es-deep-pager/dsl subpackage, letting
+// Search, SearchIter and SearchPIT accept a typed query tree instead of a hand-written JSON string.
+type Query interface {
+	Source() (any, error)
+}
+
+// resolveQuery turns the query parameter of Search, SearchIter and SearchPIT into a JSON query
+// string. query may be nil, a raw JSON string, or a Query built with the dsl subpackage.
+func resolveQuery(query any) (string, error) {
+	switch q := query.(type) {
+	case nil:
+		return "{\"match_all\":{}}", nil
+	case string:
+		if q == "" {
+			return "{\"match_all\":{}}", nil
+		}
+		return q, nil
+	case Query:
+		source, err := q.Source()
+		if err != nil {
+			return "", err
+		}
+		sourceJson, err := json.Marshal(source)
+		if err != nil {
+			return "", err
+		}
+		return string(sourceJson), nil
+	default:
+		return "", fmt.Errorf("query must be a string or dsl.Query, got %T", query)
+	}
+}
+
 // Search method, receives parameters such as index, queryDsl, from, size, etc., and call the searchAPI
 // of elasticsearch to query data.
 // Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-your-data.html
@@ -64,8 +174,9 @@ const (
 // For lower versions of elasticsearch, it can contains type, e.g. my-index/my-type.
 // Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-search.html
 //
-// The `query` parameter specifies the Query Dsl for query, this is a json formatted string, e.g. {"match_all":{}}.
-// This will be placed in the "query" field of the request body.
+// The `query` parameter specifies the Query Dsl for query. It can be a json formatted string,
+// e.g. {"match_all":{}}, or a Query built with the wantalgh/es-deep-pager/dsl subpackage; either
+// way it is placed in the "query" field of the request body.
 // If not specified, the search will return all documents in the index.
 // Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl.html
 //
@@ -74,14 +185,13 @@ const (
 // If not specified, The query will return fields based on the default settings of the index.
 // Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-fields.html
 //
-// The `sort` parameter specifies the sort field for query, e.g. "id". This will be placed in the "sort" field of the request body.
+// The `sort` parameter specifies the sort fields for query, e.g. []SortField{{Field: "id", Type: Long, Asc: true}}.
+// This will be placed in the "sort" field of the request body.
 // In order to implement fast large from parameter query, the queried data must be a well-ordered set.
-// All the documents to be queried must have at least one unique number field, which is a numeric type and stores the
-// unique number of each document. The available range of the number is the entire long integer, which can be negative
-// and discontinuous, but the number of each document must not be repeated.
-// When performing fast from query, a unique number field must be used as sorting.
-//
-// The `asc` parameter specifies the Sort order of the unique number field, if true, means ascending, if false, means descending.
+// The leading sort field must be unique across all documents; it is the one the binary search that
+// powers large `from` values bisects on. It may be a Long, Date, Keyword or Double field. Any
+// trailing sort fields are tie-breakers used only to disambiguate the batch-walk phase's
+// search_after, and need not be unique.
 //
 // The `from` parameter specifies the Starting document offset, how many documents to skip. a non-negative number. e.g. 100000000
 // Using this client, you can use very large from parameter without changing the default max_result_window setting of the index.
@@ -89,36 +199,221 @@ const (
 // The `size` parameter specifies the the number of hits to return. a non-negative number. e.g. 1000000
 // Using this client, you can use large value parameter without changing the default max_result_window setting of the index.
 //
+// The `ctx` parameter controls cancellation and deadlines for every HTTP call the search makes; it
+// is also where a per-call SearchOption such as WithRetrier is attached.
+//
+// The `opts` parameter overrides DeepPageClient's defaults for this call only, e.g. WithRetrier.
+//
 // Returns a slice of maps containing the search results and an error if the search fails.
 // If no documents match the query, an empty array is returned.
-func (client *DeepPageClient) Search(index string, query string, source *[]string, sort string, asc bool, from int64, size int64) (*[]map[string]any, error) {
+func (client *DeepPageClient) Search(ctx context.Context, index string, query any, source *[]string, sort []SortField, from int64, size int64, opts ...SearchOption) (*[]map[string]any, error) {
+
+	cfg := newSearchConfig(opts)
+	ctx = withRetrier(ctx, cfg.retrier)
 
 	if index == "" {
 		return nil, errors.New("index must be specified")
 	}
+	queryJson, err := resolveQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := indexScope{client: client, index: index}
+	newQuery, newSort, newFrom, size, reverse, empty, err := client.prepareSearch(ctx, scope, queryJson, sort, from, size)
+	if err != nil {
+		return nil, err
+	}
+	if empty {
+		return &[]map[string]any{}, nil
+	}
+
+	list, err := client.walkBatches(ctx, scope, newQuery, source, newSort, newFrom, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// If result is reverse query data, reverse it back.
+	if reverse {
+		reverseList(*list)
+	}
+
+	return list, nil
+}
+
+// SearchIter behaves like Search, but instead of buffering the entire page in memory, it returns a
+// ResultIterator that decodes and yields one hit at a time. This is intended for bulk-export style
+// use cases where `from`/`size` are large enough that the buffered result would be too big to hold
+// in memory at once.
+//
+// The underlying query runs on a background goroutine; callers must keep calling Next until it
+// returns io.EOF or an error, or call Close to stop early and release the goroutine. Cancelling
+// ctx also stops the background goroutine, surfaced to the caller as ctx.Err() from Next.
+//
+// The `opts` parameter overrides DeepPageClient's defaults for this call only, e.g. WithRetrier.
+func (client *DeepPageClient) SearchIter(ctx context.Context, index string, query any, source *[]string, sort []SortField, from int64, size int64, opts ...SearchOption) (*ResultIterator, error) {
+
+	cfg := newSearchConfig(opts)
+	ctx = withRetrier(ctx, cfg.retrier)
+
+	if index == "" {
+		return nil, errors.New("index must be specified")
+	}
+	queryJson, err := resolveQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := indexScope{client: client, index: index}
+	newQuery, newSort, newFrom, size, reverse, empty, err := client.prepareSearch(ctx, scope, queryJson, sort, from, size)
+	if err != nil {
+		return nil, err
+	}
+
+	it := newResultIterator()
+	if empty {
+		close(it.hits)
+		return it, nil
+	}
+
+	go client.runSearchIter(ctx, it, scope, source, newSort, newQuery, newFrom, size, reverse)
+	return it, nil
+}
+
+// SearchPIT behaves like Search, taking the same parameters and returning the same result, but
+// guarantees a consistent snapshot of the data across the whole deep page walk.
+//
+// Search issues an initial _count, two min/max probes, O(log N) binary-search _count calls and
+// finally the batch _search calls, all against the live index; documents indexed, updated or
+// deleted between those calls can shift results and make the walk double-count or skip documents
+// near the binary-search pivot. SearchPIT instead opens an Elasticsearch Point In Time for index,
+// routes every one of those calls through it, and closes the PIT once the walk is done, so they
+// all see the same snapshot. As in Search, the batch-walk phase uses native search_after, passing
+// back the previous batch's full sort tuple.
+//
+// PIT is only available on Elasticsearch 7.10 and later. If opening the PIT fails, SearchPIT falls
+// back to the live walk that Search performs.
+//
+// The `opts` parameter overrides DeepPageClient's defaults for this call only, e.g. WithRetrier.
+func (client *DeepPageClient) SearchPIT(ctx context.Context, index string, query any, source *[]string, sort []SortField, from int64, size int64, opts ...SearchOption) (*[]map[string]any, error) {
+
+	cfg := newSearchConfig(opts)
+	ctx = withRetrier(ctx, cfg.retrier)
+
+	if index == "" {
+		return nil, errors.New("index must be specified")
+	}
+	if len(sort) == 0 {
+		return nil, errors.New("sort must specify at least one field")
+	}
 	if from < 0 || size < 0 {
 		return nil, errors.New("from and size must be greater than 0")
 	}
 	if size == 0 {
 		return &[]map[string]any{}, nil
 	}
+	queryJson, err := resolveQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	pitId, err := client.openPIT(ctx, index, defaultPitKeepAlive)
+	if err != nil {
+		// PIT is unavailable (e.g. Elasticsearch < 7.10); fall back to the live, filter-based walk.
+		return client.Search(ctx, index, queryJson, source, sort, from, size, opts...)
+	}
+	defer client.closePIT(ctx, pitId)
+
+	scope := pitScope{client: client, pitId: pitId}
+	newQuery, newSort, newFrom, size, reverse, empty, err := client.prepareSearch(ctx, scope, queryJson, sort, from, size)
+	if err != nil {
+		return nil, err
+	}
+	if empty {
+		return &[]map[string]any{}, nil
+	}
+
+	list, err := client.walkBatches(ctx, scope, newQuery, source, newSort, newFrom, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// If result is reverse query data, reverse it back.
+	if reverse {
+		reverseList(*list)
+	}
+
+	return list, nil
+}
+
+// searchScope is the count/search pair findNewFrom, prepareSearch and walkBatches run their calls
+// through: either a plain index (indexScope, used by Search/SearchIter) or a Point In Time
+// snapshot (pitScope, used by SearchPIT). Routing both through the same interface lets the
+// binary-search and batch-walk algorithm be written once and shared by both call styles.
+type searchScope interface {
+	count(ctx context.Context, query string) (int64, error)
+	search(ctx context.Context, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error)
+}
+
+// indexScope is the searchScope for a plain, live search against index.
+type indexScope struct {
+	client *DeepPageClient
+	index  string
+}
+
+func (s indexScope) count(ctx context.Context, query string) (int64, error) {
+	return s.client.count(ctx, s.index, query)
+}
+
+func (s indexScope) search(ctx context.Context, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	return s.client.query(ctx, s.index, query, source, sort, from, size, searchAfter)
+}
+
+// pitScope is the searchScope for a search routed through a Point In Time snapshot.
+type pitScope struct {
+	client *DeepPageClient
+	pitId  string
+}
+
+func (s pitScope) count(ctx context.Context, query string) (int64, error) {
+	return s.client.countPIT(ctx, s.pitId, query)
+}
+
+func (s pitScope) search(ctx context.Context, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	return s.client.queryPIT(ctx, s.pitId, query, source, sort, from, size, searchAfter)
+}
+
+// prepareSearch validates the Search/SearchIter/SearchPIT parameters and, when from is large,
+// narrows the query down to a smaller equivalent from value by binary-searching the leading sort
+// field via scope. It returns the narrowed query, the (possibly direction-flipped) sort and size,
+// whether the walk direction was reversed, and whether the result is already known to be empty.
+func (client *DeepPageClient) prepareSearch(ctx context.Context, scope searchScope, query string, sort []SortField, from int64, size int64) (newQuery string, newSort []SortField, newFrom int64, newSize int64, reverse bool, empty bool, err error) {
+
+	if len(sort) == 0 {
+		return "", nil, 0, 0, false, false, errors.New("sort must specify at least one field")
+	}
+	if from < 0 || size < 0 {
+		return "", nil, 0, 0, false, false, errors.New("from and size must be greater than 0")
+	}
+	if size == 0 {
+		return "", sort, 0, 0, false, true, nil
+	}
 	if query == "" {
 		query = "{\"match_all\":{}}"
 	}
 
 	// When the queried data is near the end of the data set, reverse the query direction.
-	reverse := false
 	if from > maxFrom {
-		total, err := client.count(index, query)
+		total, err := scope.count(ctx, query)
 		if err != nil {
-			return nil, err
+			return "", nil, 0, 0, false, false, err
 		}
 		if total == 0 || from > total {
-			return &[]map[string]any{}, nil
+			return "", sort, 0, 0, false, true, nil
 		}
 		reverse = from > (total - from)
 		if reverse {
-			asc = !asc
+			sort = reverseSort(sort)
 			from2 := total - from - size
 			size2 := size
 			if from2 < 0 {
@@ -127,48 +422,68 @@ func (client *DeepPageClient) Search(index string, query string, source *[]strin
 			from = maximum(from2, 0)
 			size = maximum(size2, 0)
 			if size == 0 {
-				return &[]map[string]any{}, nil
+				return "", sort, 0, 0, false, true, nil
 			}
 		}
 	}
 
-	// When the from parameter is large, find a sort value that can exclude some of the from data, and reduce the from value.
-	newQuery := query
-	newFrom := from
+	// When the from parameter is large, find a pivot value on the leading sort field that can
+	// exclude some of the from data, and reduce the from value.
+	leading := sort[0]
+	newQuery = query
+	newFrom = from
 	if from > maxFrom {
-		minItem, err := client.query(index, query, &[]string{sort}, sort, true, 0, 1)
+		minItem, err := scope.search(ctx, query, &[]string{leading.Field}, []SortField{{leading.Field, leading.Type, true}}, 0, 1, nil)
 		if err != nil {
-			return nil, err
+			return "", nil, 0, 0, false, false, err
+		}
+		pivotMin, err := parsePivot(leading.Type, ((*minItem)[0])["_source"].(map[string]any)[leading.Field])
+		if err != nil {
+			return "", nil, 0, 0, false, false, err
 		}
 
-		sortMin := int64(((*minItem)[0])["_source"].(map[string]any)[sort].(float64))
-		maxItem, err := client.query(index, query, &[]string{sort}, sort, false, 0, 1)
+		maxItem, err := scope.search(ctx, query, &[]string{leading.Field}, []SortField{{leading.Field, leading.Type, false}}, 0, 1, nil)
 		if err != nil {
-			return nil, err
+			return "", nil, 0, 0, false, false, err
+		}
+		pivotMax, err := parsePivot(leading.Type, ((*maxItem)[0])["_source"].(map[string]any)[leading.Field])
+		if err != nil {
+			return "", nil, 0, 0, false, false, err
 		}
-		sortMax := int64(((*maxItem)[0])["_source"].(map[string]any)[sort].(float64))
 
-		if asc {
-			newStart, newForm2, err := client.findNewFrom(index, query, sort, sortMin, sortMax, from)
+		if leading.Asc {
+			newStart, newFrom2, err := client.findNewFrom(ctx, scope, query, leading, pivotMin, pivotMax, from)
 			if err != nil {
-				return nil, err
+				return "", nil, 0, 0, false, false, err
+			}
+			newFrom = newFrom2
+			newQuery, err = buildCmpQuery(query, leading.Field, "gt", newStart)
+			if err != nil {
+				return "", nil, 0, 0, false, false, err
 			}
-			newFrom = newForm2
-			newQuery = buildCmpQuery(query, sort, "gt", newStart)
 		} else {
-			newStart, newFrom2, err := client.findNewFrom(index, query, sort, sortMax, sortMin, from)
+			newStart, newFrom2, err := client.findNewFrom(ctx, scope, query, leading, pivotMax, pivotMin, from)
 			if err != nil {
-				return nil, err
+				return "", nil, 0, 0, false, false, err
 			}
 			newFrom = newFrom2
-			newQuery = buildCmpQuery(query, sort, "lt", newStart)
+			newQuery, err = buildCmpQuery(query, leading.Field, "lt", newStart)
+			if err != nil {
+				return "", nil, 0, 0, false, false, err
+			}
 		}
 	}
 
-	// When the size parameter is large, query data in batches to reduce the size value.
+	return newQuery, sort, newFrom, size, reverse, false, nil
+}
+
+// walkBatches runs query against scope in batches of at most maxSize, walking forward with
+// search_after over the full sort tuple after the first batch, until size hits have been
+// collected or scope is exhausted. Search, SearchPIT and runSearchIter all walk batches this way.
+func (client *DeepPageClient) walkBatches(ctx context.Context, scope searchScope, query string, source *[]string, sort []SortField, from int64, size int64) (*[]map[string]any, error) {
 	remainSize := size
 	retrieveSize := minimum(size, maxSize)
-	batch, err := client.query(index, newQuery, source, sort, asc, newFrom, retrieveSize)
+	batch, err := scope.search(ctx, query, source, sort, from, retrieveSize, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -180,14 +495,9 @@ func (client *DeepPageClient) Search(index string, query string, source *[]strin
 	list = append(list, *batch...)
 	remainSize -= int64(len(*batch))
 	for remainSize > 0 {
-		lastSort := int64(((*batch)[len(*batch)-1])["sort"].([]any)[0].(float64))
-		if asc {
-			newQuery = buildCmpQuery(query, sort, "gt", lastSort)
-		} else {
-			newQuery = buildCmpQuery(query, sort, "lt", lastSort)
-		}
+		searchAfter := (*batch)[len(*batch)-1]["sort"].([]any)
 		retrieveSize = minimum(remainSize, maxSize)
-		batch, err = client.query(index, newQuery, source, sort, asc, 0, retrieveSize)
+		batch, err = scope.search(ctx, query, source, sort, 0, retrieveSize, searchAfter)
 		if err != nil {
 			return nil, err
 		}
@@ -198,14 +508,89 @@ func (client *DeepPageClient) Search(index string, query string, source *[]strin
 		remainSize -= int64(len(*batch))
 	}
 
-	// If result is reverse query data, reverse it back.
+	return &list, nil
+}
+
+// reverseList reverses list in place, used to restore result order after a reverse query walk.
+func reverseList(list []map[string]any) {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+}
+
+// runSearchIter walks the batches for SearchIter and feeds each hit into it.hits, in the same order
+// Search would return them in. It owns it.hits and it.errc and closes it.hits when done, whether
+// that is because the walk is exhausted, it failed, the caller called Close, or ctx was cancelled.
+func (client *DeepPageClient) runSearchIter(ctx context.Context, it *ResultIterator, scope searchScope, source *[]string, sort []SortField, newQuery string, newFrom int64, size int64, reverse bool) {
+	defer close(it.hits)
+
+	emit := func(hit map[string]any) bool {
+		select {
+		case it.hits <- hit:
+			return true
+		case <-it.cancel:
+			return false
+		case <-ctx.Done():
+			it.errc <- ctx.Err()
+			return false
+		}
+	}
+
+	remainSize := size
+	retrieveSize := minimum(size, maxSize)
+	batch, err := scope.search(ctx, newQuery, source, sort, newFrom, retrieveSize, nil)
+	if err != nil {
+		it.errc <- err
+		return
+	}
+
+	// Reverse queries are walked back-to-front internally, so the hits must be collected before
+	// they can be emitted in the order the caller asked for.
+	var reversed []map[string]any
+	remainSize -= int64(len(*batch))
 	if reverse {
-		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
-			list[i], list[j] = list[j], list[i]
+		reversed = append(reversed, *batch...)
+	} else {
+		for _, hit := range *batch {
+			if !emit(hit) {
+				return
+			}
 		}
 	}
 
-	return &list, nil
+	for remainSize > 0 && len(*batch) > 0 {
+		select {
+		case <-it.cancel:
+			return
+		default:
+		}
+		searchAfter := (*batch)[len(*batch)-1]["sort"].([]any)
+		var err error
+		retrieveSize = minimum(remainSize, maxSize)
+		batch, err = scope.search(ctx, newQuery, source, sort, 0, retrieveSize, searchAfter)
+		if err != nil {
+			it.errc <- err
+			return
+		}
+		remainSize -= int64(len(*batch))
+		if reverse {
+			reversed = append(reversed, *batch...)
+		} else {
+			for _, hit := range *batch {
+				if !emit(hit) {
+					return
+				}
+			}
+		}
+	}
+
+	if reverse {
+		for i := len(reversed) - 1; i >= 0; i-- {
+			if !emit(reversed[i]) {
+				return
+			}
+		}
+	}
 }
 
 // iif returns truePart if expr is true, and falsePart otherwise.
@@ -226,38 +611,47 @@ func minimum(i1, i2 int64) int64 {
 	return iif(i1 < i2, i1, i2)
 }
 
-// Returns the distance of i1 and i2.
-func distance(i1, i2 int64) int64 {
-	return iif(i1 > i2, i1-i2, i2-i1)
-}
-
-// Use binary search to find new query parameters with the same result as the original query but with a smaller from value.
-func (client *DeepPageClient) findNewFrom(index string, query string, sort string, sortStart int64, sortEnd int64, from int64) (int64, int64, error) {
-	newStart := sortStart
-	newEnd := sortEnd
-	var newFrom int64
+// Use binary search to find a pivot value on field.Field with the same result as the original
+// query but with a smaller from value, bisecting the Pivot space between pivotStart and pivotEnd
+// instead of only over long integers.
+func (client *DeepPageClient) findNewFrom(ctx context.Context, scope searchScope, query string, field SortField, pivotStart Pivot, pivotEnd Pivot, from int64) (Pivot, int64, error) {
+	newStart := pivotStart
+	newEnd := pivotEnd
+	ascending := pivotStart.Cmp(pivotEnd) < 0
+	newFrom := from
 	for {
-		sortMin := minimum(newStart, newEnd)
-		sortAbs := distance(newStart, newEnd)
-		if sortAbs <= 1 {
-			return sortMin, sortAbs, nil
+		var lo, hi Pivot
+		if ascending {
+			lo, hi = newStart, newEnd
+		} else {
+			lo, hi = newEnd, newStart
+		}
+		mid := lo.Mid(hi)
+		if mid.Cmp(lo) == 0 || mid.Cmp(hi) == 0 {
+			// lo and hi can no longer be bisected; stop here and let the batch-walk query skip
+			// whatever of newFrom is still left with a plain `from`.
+			break
 		}
-		sortMid := sortMin + sortAbs/2
+
 		var midQuery string
-		if sortStart < sortEnd {
-			midQuery = buildRangeQuery(query, sort, sortStart, sortMid)
+		var err error
+		if ascending {
+			midQuery, err = buildRangeQuery(query, field.Field, pivotStart, mid)
 		} else {
-			midQuery = buildRangeQuery(query, sort, sortMid, sortStart)
+			midQuery, err = buildRangeQuery(query, field.Field, mid, pivotStart)
+		}
+		if err != nil {
+			return nil, 0, err
 		}
-		midCount, err := client.count(index, midQuery)
+		midCount, err := scope.count(ctx, midQuery)
 		if err != nil {
-			return 0, 0, err
+			return nil, 0, err
 		}
 		newFrom = from - midCount
 		if newFrom < 0 {
-			newEnd = sortMid
+			newEnd = mid
 		} else {
-			newStart = sortMid
+			newStart = mid
 			if newFrom <= maxFrom {
 				break
 			}
@@ -267,88 +661,129 @@ func (client *DeepPageClient) findNewFrom(index string, query string, sort strin
 	return newStart, newFrom, nil
 }
 
-// Add range restrictions to the original query.
-func buildCmpQuery(query string, sort string, cmp string, value int64) string {
-	template := "{\"bool\":{\"must\":%s,\"filter\":{\"range\":{\"%s\":{\"%s\":%d}}}}}"
-	return fmt.Sprintf(template, query, sort, cmp, value)
+// Add a one-sided range restriction on field to the original query.
+func buildCmpQuery(query string, field string, cmp string, pivot Pivot) (string, error) {
+	filter := dsl.Range(field)
+	switch cmp {
+	case "gt":
+		filter.Gt(pivot.Value())
+	case "lt":
+		filter.Lt(pivot.Value())
+	}
+	return marshalQuery(dsl.Bool().Must(dsl.Raw(query)).Filter(filter))
 }
 
-// Add range restrictions to the original query.
-func buildRangeQuery(query string, sort string, start int64, end int64) string {
-	template := "{\"bool\":{\"must\":%s,\"filter\":{\"range\":{\"%s\":{\"gte\":%d,\"lte\":%d}}}}}"
-	return fmt.Sprintf(template, query, sort, start, end)
+// Add a two-sided range restriction on field to the original query.
+func buildRangeQuery(query string, field string, lo Pivot, hi Pivot) (string, error) {
+	filter := dsl.Range(field).Gte(lo.Value()).Lte(hi.Value())
+	return marshalQuery(dsl.Bool().Must(dsl.Raw(query)).Filter(filter))
 }
 
-// Call elasticsearch's countAPI to get the total number of documents that meet query conditions.
-func (client *DeepPageClient) count(index string, query string) (int64, error) {
-	url := index + "/_count"
-	body := "{\"query\": " + query + "}"
-	resp, err := client.postJson(url, body)
+// marshalQuery resolves q's Source and marshals it to a JSON query string.
+func marshalQuery(q dsl.Query) (string, error) {
+	source, err := q.Source()
 	if err != nil {
-		return 0, err
+		return "", err
 	}
+	sourceJson, err := json.Marshal(source)
+	if err != nil {
+		return "", err
+	}
+	return string(sourceJson), nil
+}
 
-	var result map[string]any
-	json.Unmarshal([]byte(resp), &result)
+// Call elasticsearch's countAPI to get the total number of documents that meet query conditions.
+func (client *DeepPageClient) count(ctx context.Context, index string, query string) (int64, error) {
+	return client.backend().count(ctx, index, query)
+}
 
-	count := int64(result["count"].(float64))
-	return count, nil
+// Call elasticsearch's searchAPI to get the documents that meet the conditions. When searchAfter
+// is not nil, it is sent verbatim as the request's search_after and from is ignored, per the
+// searchAPI's rules.
+func (client *DeepPageClient) query(ctx context.Context, index string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	return client.backend().search(ctx, index, query, source, sort, from, size, searchAfter)
 }
 
-// Call elasticsearch's searchAPI to get the documents that meet the conditions.
-func (client *DeepPageClient) query(index string, query string, source *[]string, sort string, asc bool, from int64, size int64) (*[]map[string]any, error) {
+// Open a Point In Time on index, kept alive for keepAlive (e.g. "1m"), and return its id.
+// Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/point-in-time-api.html
+func (client *DeepPageClient) openPIT(ctx context.Context, index string, keepAlive string) (string, error) {
+	return client.backend().openPIT(ctx, index, keepAlive)
+}
 
-	url := index + "/_search"
+// Close a Point In Time previously opened by openPIT.
+func (client *DeepPageClient) closePIT(ctx context.Context, pitId string) error {
+	return client.backend().closePIT(ctx, pitId)
+}
 
-	queryBuilder := strings.Builder{}
-	queryBuilder.WriteString("{")
-	queryBuilder.WriteString("\"query\": " + query + ",")
-	queryBuilder.WriteString("\"sort\": {\"" + sort + "\":\"" + iif(asc, "asc", "desc") + "\"},")
-	if source != nil {
-		sourceStr := make([]string, 0)
-		for _, value := range *source {
-			sourceStr = append(sourceStr, "\""+value+"\"")
+// Call elasticsearch's searchAPI with a Point In Time to get the total number of documents that
+// meet query conditions within that PIT's snapshot. size is 0 so only the hit count is computed.
+func (client *DeepPageClient) countPIT(ctx context.Context, pitId string, query string) (int64, error) {
+	return client.backend().countPIT(ctx, pitId, query)
+}
+
+// Call elasticsearch's searchAPI with a Point In Time to get the documents that meet the
+// conditions within that PIT's snapshot. When searchAfter is not nil, it is sent verbatim as the
+// request's search_after and from is ignored, per the searchAPI's rules.
+func (client *DeepPageClient) queryPIT(ctx context.Context, pitId string, query string, source *[]string, sort []SortField, from int64, size int64, searchAfter []any) (*[]map[string]any, error) {
+	return client.backend().searchPIT(ctx, pitId, query, source, sort, from, size, searchAfter)
+}
+
+// Call elasticsearch low level rest client, send a json request with the given http method.
+// Retries on network errors, 429 and 5xx responses, using client.retrierFor(ctx).
+func (client *DeepPageClient) doJson(ctx context.Context, method string, url string, body string) (string, error) {
+
+	retrier := client.retrierFor(ctx)
+
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Transport.Perform(req)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			defer resp.Body.Close()
+			bytes, readErr := io.ReadAll(resp.Body)
+			if readErr != nil {
+				return "", readErr
+			}
+			return string(bytes), nil
 		}
-		queryBuilder.WriteString("\"_source\": " + "[" + strings.Join(sourceStr, ",") + "],")
-	}
-	queryBuilder.WriteString("\"from\":" + strconv.FormatInt(from, 10) + ",")
-	queryBuilder.WriteString("\"size\":" + strconv.FormatInt(size, 10))
-	queryBuilder.WriteString("}")
 
-	resp, err := client.postJson(url, queryBuilder.String())
-	if err != nil {
-		return nil, err
-	}
+		var respErr error
+		if err == nil {
+			respErr = errors.New(resp.Status)
+			resp.Body.Close()
+		}
 
-	var result map[string]any
-	json.Unmarshal([]byte(resp), &result)
+		wait, retryable, retryErr := retrier.Retry(ctx, retry, req, resp, err)
+		if retryErr != nil {
+			return "", retryErr
+		}
+		if !retryable {
+			if err != nil {
+				return "", err
+			}
+			return "", respErr
+		}
 
-	var hits []map[string]any
-	for _, value := range (result["hits"].(map[string]any))["hits"].([]any) {
-		hits = append(hits, value.(map[string]any))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
 	}
-	return &hits, nil
 }
 
 // Call elasticsearch low level rest client, post json to elasticsearch cluster.
-func (client *DeepPageClient) postJson(url string, body string) (string, error) {
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := client.Transport.Perform(req)
-	if err != nil {
-		return "", err
-	}
+func (client *DeepPageClient) postJson(ctx context.Context, url string, body string) (string, error) {
+	return client.doJson(ctx, "POST", url, body)
+}
 
-	defer resp.Body.Close()
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	err = iif(resp.StatusCode == 200, nil, errors.New(resp.Status))
-	return string(bytes), err
+// Call elasticsearch low level rest client, delete with a json body.
+func (client *DeepPageClient) deleteJson(ctx context.Context, url string, body string) (string, error) {
+	return client.doJson(ctx, "DELETE", url, body)
 }
@@ -0,0 +1,173 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package dsl is a small typed builder for Elasticsearch Query DSL trees, to be passed to
+// DeepPageClient.Search instead of a hand-written or string-concatenated JSON query.
+//
+// Reference: https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl.html
+package dsl
+
+import "encoding/json"
+
+// Query is implemented by every builder in this package. Source returns a value that
+// encoding/json can marshal into the query's JSON representation.
+type Query interface {
+	Source() (any, error)
+}
+
+// Raw wraps an already-encoded JSON query fragment, e.g. a caller-supplied string, so it can be
+// composed with the other builders, such as inside Bool().Must(...).
+type Raw string
+
+// Source returns r as a json.RawMessage, so it is embedded in the surrounding JSON verbatim.
+func (r Raw) Source() (any, error) {
+	return json.RawMessage(r), nil
+}
+
+// MatchAllQuery matches every document. Build one with MatchAll.
+type MatchAllQuery struct{}
+
+// MatchAll returns a query that matches every document.
+func MatchAll() *MatchAllQuery {
+	return &MatchAllQuery{}
+}
+
+// Source returns the match_all query's JSON representation.
+func (q *MatchAllQuery) Source() (any, error) {
+	return map[string]any{"match_all": map[string]any{}}, nil
+}
+
+// TermQuery matches documents where a field has an exact value. Build one with Term.
+type TermQuery struct {
+	field string
+	value any
+}
+
+// Term returns a query that matches documents where field has the exact given value.
+func Term(field string, value any) *TermQuery {
+	return &TermQuery{field: field, value: value}
+}
+
+// Source returns the term query's JSON representation.
+func (q *TermQuery) Source() (any, error) {
+	return map[string]any{"term": map[string]any{q.field: q.value}}, nil
+}
+
+// RangeQuery matches documents where a field falls within given bounds. Build one with Range,
+// then chain Gt/Gte/Lt/Lte to set its bounds.
+type RangeQuery struct {
+	field  string
+	bounds map[string]any
+}
+
+// Range returns a range query builder for field.
+func Range(field string) *RangeQuery {
+	return &RangeQuery{field: field, bounds: map[string]any{}}
+}
+
+// Gt sets the range's exclusive lower bound.
+func (q *RangeQuery) Gt(value any) *RangeQuery {
+	q.bounds["gt"] = value
+	return q
+}
+
+// Gte sets the range's inclusive lower bound.
+func (q *RangeQuery) Gte(value any) *RangeQuery {
+	q.bounds["gte"] = value
+	return q
+}
+
+// Lt sets the range's exclusive upper bound.
+func (q *RangeQuery) Lt(value any) *RangeQuery {
+	q.bounds["lt"] = value
+	return q
+}
+
+// Lte sets the range's inclusive upper bound.
+func (q *RangeQuery) Lte(value any) *RangeQuery {
+	q.bounds["lte"] = value
+	return q
+}
+
+// Source returns the range query's JSON representation.
+func (q *RangeQuery) Source() (any, error) {
+	return map[string]any{"range": map[string]any{q.field: q.bounds}}, nil
+}
+
+// BoolQuery composes other queries with must and filter clauses. Build one with Bool.
+type BoolQuery struct {
+	must   []Query
+	filter []Query
+}
+
+// Bool returns a bool query builder.
+func Bool() *BoolQuery {
+	return &BoolQuery{}
+}
+
+// Must adds clauses that must match, and contribute to the score, like a bool query's "must".
+func (q *BoolQuery) Must(queries ...Query) *BoolQuery {
+	q.must = append(q.must, queries...)
+	return q
+}
+
+// Filter adds clauses that must match, without contributing to the score, like a bool query's
+// "filter".
+func (q *BoolQuery) Filter(queries ...Query) *BoolQuery {
+	q.filter = append(q.filter, queries...)
+	return q
+}
+
+// Source returns the bool query's JSON representation.
+func (q *BoolQuery) Source() (any, error) {
+	clause := map[string]any{}
+	if len(q.must) > 0 {
+		source, err := sources(q.must)
+		if err != nil {
+			return nil, err
+		}
+		clause["must"] = source
+	}
+	if len(q.filter) > 0 {
+		source, err := sources(q.filter)
+		if err != nil {
+			return nil, err
+		}
+		clause["filter"] = source
+	}
+	return map[string]any{"bool": clause}, nil
+}
+
+// sources resolves Source for each query in queries, in order.
+func sources(queries []Query) ([]any, error) {
+	result := make([]any, 0, len(queries))
+	for _, query := range queries {
+		source, err := query.Source()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, source)
+	}
+	return result, nil
+}
@@ -0,0 +1,186 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff determines how long to wait between retry attempts. retry is 0 on the first retry.
+type Backoff interface {
+	// Next returns the duration to wait before the given retry attempt, and whether to retry at
+	// all; once it returns false, the caller gives up.
+	Next(retry int) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a Backoff that grows the wait interval exponentially between
+// InitialInterval and MaxInterval, randomly jittered to spread out retries, and gives up after
+// MaxRetries attempts.
+type ExponentialBackoff struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxRetries      int
+}
+
+// NewExponentialBackoff returns an ExponentialBackoff with the given initial interval, max
+// interval and max number of retries.
+func NewExponentialBackoff(initialInterval time.Duration, maxInterval time.Duration, maxRetries int) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		InitialInterval: initialInterval,
+		MaxInterval:     maxInterval,
+		MaxRetries:      maxRetries,
+	}
+}
+
+// Next returns an exponentially growing, jittered wait interval, or false once retry reaches
+// MaxRetries.
+func (b *ExponentialBackoff) Next(retry int) (time.Duration, bool) {
+	if retry >= b.MaxRetries {
+		return 0, false
+	}
+	interval := float64(b.InitialInterval) * math.Pow(2, float64(retry))
+	if interval > float64(b.MaxInterval) {
+		interval = float64(b.MaxInterval)
+	}
+	jittered := interval/2 + rand.Float64()*interval/2
+	return time.Duration(jittered), true
+}
+
+// Retrier decides whether and how long to wait before retrying an HTTP call that failed or
+// returned a retryable status. Modeled on olivere/elastic's Retrier.
+// Reference: https://github.com/olivere/elastic/blob/release-branch.v7/retrier.go
+type Retrier interface {
+	// Retry is called after req fails (err != nil) or completes (resp != nil). It returns how
+	// long to wait before retrying, whether to retry at all, and an error to return to the
+	// caller instead of retrying.
+	Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error)
+}
+
+// DefaultRetrier is the Retrier DeepPageClient falls back to when none is configured. It retries
+// network errors, 429 Too Many Requests (honoring a Retry-After header) and 5xx responses, and
+// otherwise stops immediately.
+type DefaultRetrier struct {
+	Backoff Backoff
+}
+
+// NewDefaultRetrier returns a DefaultRetrier backed by an ExponentialBackoff with a 100ms initial
+// interval, a 30s max interval and up to 5 retries.
+func NewDefaultRetrier() *DefaultRetrier {
+	return &DefaultRetrier{Backoff: NewExponentialBackoff(100*time.Millisecond, 30*time.Second, 5)}
+}
+
+// Retry implements Retrier.
+func (r *DefaultRetrier) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
+	if ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	if err == nil && resp != nil && resp.StatusCode != http.StatusTooManyRequests && (resp.StatusCode < 500 || resp.StatusCode > 599) {
+		return 0, false, nil
+	}
+
+	wait, ok := r.Backoff.Next(retry)
+	if !ok {
+		return 0, false, nil
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+			wait = retryAfter
+		}
+	}
+	return wait, true, nil
+}
+
+// parseRetryAfter parses a Retry-After header value, either a number of seconds or an HTTP date,
+// into a duration. It returns 0 if header is empty or unparseable.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
+
+var defaultRetrier = NewDefaultRetrier()
+
+// retrierContextKey is the context.Value key SearchOption's WithRetrier stores its override
+// under, so it reaches doJson without threading an extra parameter through every call site.
+type retrierContextKey struct{}
+
+// withRetrier returns a copy of ctx carrying retrier as the per-call Retrier override. If retrier
+// is nil, ctx is returned unchanged.
+func withRetrier(ctx context.Context, retrier Retrier) context.Context {
+	if retrier == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retrierContextKey{}, retrier)
+}
+
+// retrierFor returns the Retrier that should be used for a call made with ctx: the per-call
+// override set by SearchOption, if any, else client.Retrier, else DefaultRetrier.
+func (client *DeepPageClient) retrierFor(ctx context.Context) Retrier {
+	if retrier, ok := ctx.Value(retrierContextKey{}).(Retrier); ok {
+		return retrier
+	}
+	if client.Retrier != nil {
+		return client.Retrier
+	}
+	return defaultRetrier
+}
+
+// SearchOption configures a single Search, SearchIter or SearchPIT call, overriding the
+// DeepPageClient's defaults for that call only.
+type SearchOption func(*searchConfig)
+
+// searchConfig holds the per-call overrides collected from a list of SearchOption.
+type searchConfig struct {
+	retrier Retrier
+}
+
+// newSearchConfig builds a searchConfig from opts.
+func newSearchConfig(opts []SearchOption) *searchConfig {
+	cfg := &searchConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithRetrier overrides the Retrier used for this call only, instead of client.Retrier or
+// DefaultRetrier.
+func WithRetrier(retrier Retrier) SearchOption {
+	return func(cfg *searchConfig) {
+		cfg.retrier = retrier
+	}
+}
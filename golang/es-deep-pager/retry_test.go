@@ -0,0 +1,82 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfterSeconds(t *testing.T) {
+	got := parseRetryAfter("5")
+	want := 5 * time.Second
+	if got != want {
+		t.Errorf("parseRetryAfter(\"5\") = %v, want %v", got, want)
+	}
+}
+
+func TestParseRetryAfterHTTPDate(t *testing.T) {
+	when := time.Now().Add(10 * time.Second).UTC()
+	got := parseRetryAfter(when.Format(http.TimeFormat))
+	if got <= 0 || got > 10*time.Second {
+		t.Errorf("parseRetryAfter(%v) = %v, want a positive duration close to 10s", when, got)
+	}
+}
+
+func TestParseRetryAfterEmpty(t *testing.T) {
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("parseRetryAfter(\"\") = %v, want 0", got)
+	}
+}
+
+func TestParseRetryAfterInvalid(t *testing.T) {
+	if got := parseRetryAfter("not a valid value"); got != 0 {
+		t.Errorf("parseRetryAfter(invalid) = %v, want 0", got)
+	}
+}
+
+func TestExponentialBackoffGivesUpAtMaxRetries(t *testing.T) {
+	b := NewExponentialBackoff(time.Millisecond, time.Second, 3)
+	for retry := 0; retry < 3; retry++ {
+		if _, ok := b.Next(retry); !ok {
+			t.Fatalf("Next(%d) = false, want true (retry %d of 3)", retry, retry)
+		}
+	}
+	if _, ok := b.Next(3); ok {
+		t.Error("Next(3) = true, want false once MaxRetries is reached")
+	}
+}
+
+func TestExponentialBackoffCapsAtMaxInterval(t *testing.T) {
+	b := NewExponentialBackoff(time.Second, 2*time.Second, 10)
+	wait, ok := b.Next(5)
+	if !ok {
+		t.Fatal("Next(5) = false, want true")
+	}
+	if wait > 2*time.Second {
+		t.Errorf("Next(5) = %v, want capped at MaxInterval %v", wait, 2*time.Second)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SortFieldType identifies the Elasticsearch field type of a SortField, determining how its values
+// are parsed out of a hit and compared/bisected during the binary-search phase of a deep page walk.
+type SortFieldType int
+
+const (
+	// Long sorts by a long integer field, compared and bisected as an int64.
+	Long SortFieldType = iota
+	// Date sorts by a date field, compared and bisected as milliseconds since the epoch.
+	Date
+	// Keyword sorts by a keyword (string) field, compared and bisected lexicographically.
+	Keyword
+	// Double sorts by a double field, compared and bisected as a float64.
+	Double
+)
+
+// SortField is one field of a Search/SearchIter/SearchPIT sort. The leading field must be unique
+// across all documents, the same requirement Search previously placed on its single sort field; it
+// is the pivot the binary-search phase bisects on. Any trailing fields are tie-breakers, used only
+// to disambiguate the batch-walk phase's search_after and not binary-searched on, so they need not
+// be unique.
+type SortField struct {
+	// Field is the Elasticsearch field name, e.g. "id" or "@timestamp".
+	Field string
+	// Type is the field's Elasticsearch type, determining how its values compare and bisect.
+	Type SortFieldType
+	// Asc is the sort direction: true for ascending, false for descending.
+	Asc bool
+}
+
+// reverseSort returns a copy of fields with every field's direction flipped, used to walk a deep
+// page backwards from the end of the result set.
+func reverseSort(fields []SortField) []SortField {
+	reversed := make([]SortField, len(fields))
+	for i, field := range fields {
+		reversed[i] = field
+		reversed[i].Asc = !field.Asc
+	}
+	return reversed
+}
+
+// sortClause renders fields as the JSON array expected by the searchAPI's "sort" request field,
+// e.g. [{"id":{"order":"asc"}},{"created_at":{"order":"asc"}}].
+func sortClause(fields []SortField) (string, error) {
+	clauses := make([]map[string]any, 0, len(fields))
+	for _, field := range fields {
+		clauses = append(clauses, map[string]any{
+			field.Field: map[string]any{"order": iif(field.Asc, "asc", "desc")},
+		})
+	}
+	encoded, err := json.Marshal(clauses)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// Pivot is a sortable field value, used by findNewFrom to bisect the binary-search
+// phase over a SortField's leading field, abstracting over the Long, Date, Keyword and Double field
+// types.
+type Pivot interface {
+	// Cmp compares this pivot to other: negative if this sorts before other, 0 if equal, positive
+	// if this sorts after other.
+	Cmp(other Pivot) int
+	// Mid returns a pivot partway between this pivot and other.
+	Mid(other Pivot) Pivot
+	// Value returns the pivot's value in the form the Elasticsearch Query DSL expects it in a range
+	// query, e.g. a number of milliseconds since the epoch for a Date pivot.
+	Value() any
+}
+
+// parsePivot reads the value of a SortFieldType-typed field out of a hit's decoded _source map,
+// and returns it as a Pivot.
+func parsePivot(fieldType SortFieldType, raw any) (Pivot, error) {
+	switch fieldType {
+	case Long:
+		value, ok := raw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("long sort field: expected a number, got %T", raw)
+		}
+		return longPivot(int64(value)), nil
+	case Double:
+		value, ok := raw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("double sort field: expected a number, got %T", raw)
+		}
+		return doublePivot(value), nil
+	case Keyword:
+		value, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("keyword sort field: expected a string, got %T", raw)
+		}
+		return keywordPivot(value), nil
+	case Date:
+		return parseDatePivot(raw)
+	default:
+		return nil, fmt.Errorf("unknown sort field type %v", fieldType)
+	}
+}
+
+// parseDatePivot reads a Date field's value, either a number of milliseconds since the epoch or an
+// RFC3339 formatted string, the two forms Elasticsearch commonly returns a date field's _source value as.
+func parseDatePivot(raw any) (Pivot, error) {
+	switch value := raw.(type) {
+	case float64:
+		return datePivot(int64(value)), nil
+	case string:
+		when, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("date sort field: %w", err)
+		}
+		return datePivot(when.UnixMilli()), nil
+	default:
+		return nil, fmt.Errorf("date sort field: expected a number or a string, got %T", raw)
+	}
+}
+
+// longPivot is a Pivot over a long integer field.
+type longPivot int64
+
+func (p longPivot) Cmp(other Pivot) int {
+	o := other.(longPivot)
+	return iif(p < o, -1, iif(p > o, 1, 0))
+}
+
+func (p longPivot) Mid(other Pivot) Pivot {
+	o := other.(longPivot)
+	return p + (o-p)/2
+}
+
+func (p longPivot) Value() any {
+	return int64(p)
+}
+
+// datePivot is a Pivot over a date field, held as milliseconds since the epoch.
+type datePivot int64
+
+func (p datePivot) Cmp(other Pivot) int {
+	o := other.(datePivot)
+	return iif(p < o, -1, iif(p > o, 1, 0))
+}
+
+func (p datePivot) Mid(other Pivot) Pivot {
+	o := other.(datePivot)
+	return p + (o-p)/2
+}
+
+func (p datePivot) Value() any {
+	return int64(p)
+}
+
+// doublePivot is a Pivot over a double field.
+type doublePivot float64
+
+func (p doublePivot) Cmp(other Pivot) int {
+	o := other.(doublePivot)
+	return iif(p < o, -1, iif(p > o, 1, 0))
+}
+
+func (p doublePivot) Mid(other Pivot) Pivot {
+	o := other.(doublePivot)
+	return p + (o-p)/2
+}
+
+func (p doublePivot) Value() any {
+	return float64(p)
+}
+
+// keywordPivot is a Pivot over a keyword field, bisected lexicographically.
+type keywordPivot string
+
+func (p keywordPivot) Cmp(other Pivot) int {
+	return strings.Compare(string(p), string(other.(keywordPivot)))
+}
+
+// Mid returns a string lexicographically between p and other, found by walking their shared byte
+// prefix and splitting the first differing byte, lengthening the result as needed when the two
+// strings are byte-adjacent at that position (e.g. "user1"/"user2") or one is a strict prefix of
+// the other. Unlike averaging the two strings as fixed-length byte integers, this never runs out
+// of precision: it always returns a value strictly between p and other, except in the one case no
+// string can exist there at all (other is exactly p with a single trailing zero byte appended).
+func (p keywordPivot) Mid(other Pivot) Pivot {
+	lo, hi := string(p), string(other.(keywordPivot))
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if lo == hi {
+		return keywordPivot(lo)
+	}
+
+	n := len(lo)
+	if len(hi) < n {
+		n = len(hi)
+	}
+	for i := 0; i < n; i++ {
+		if lo[i] == hi[i] {
+			continue
+		}
+		if hi[i]-lo[i] > 1 {
+			// A byte strictly between lo[i] and hi[i] exists; splitting there is already enough,
+			// whatever follows it in either string.
+			return keywordPivot(lo[:i] + string([]byte{lo[i] + (hi[i]-lo[i])/2}))
+		}
+		// lo[i] and hi[i] are byte-adjacent: no single byte fits between them at this position.
+		// Extending lo by one more byte sorts after lo (lo is then a strict prefix of the result)
+		// while still sorting before hi, since their prefixes already diverge at lo[i] < hi[i].
+		return keywordPivot(lo + "\x00")
+	}
+
+	// The shorter string is a strict prefix of the longer one; since lo < hi, that is lo.
+	switch next := hi[n]; {
+	case next > 0:
+		// Any byte from 0 up to (but excluding) next sorts strictly between lo and hi.
+		return keywordPivot(lo + string(next/2))
+	case len(hi) == n+1:
+		// hi is exactly lo with a single trailing zero byte appended: no string sorts strictly
+		// between them.
+		return keywordPivot(hi)
+	default:
+		// hi continues with more bytes after this zero byte, so lo+"\x00" is a strict prefix of
+		// hi (hence < hi) while still being > lo.
+		return keywordPivot(lo + "\x00")
+	}
+}
+
+func (p keywordPivot) Value() any {
+	return string(p)
+}
@@ -0,0 +1,118 @@
+/*
+MIT License
+
+Copyright (c) 2024 wantalgh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package esdeeppager
+
+import "testing"
+
+func TestKeywordPivotMidBetweenInputs(t *testing.T) {
+	cases := []struct {
+		lo, hi string
+	}{
+		{"b", "c"},
+		{"user1", "user2"},
+		{"a", "ab"},
+		{"abc", "abd"},
+		{"", "a"},
+		{"a", "b"},
+	}
+
+	for _, c := range cases {
+		mid := keywordPivot(c.lo).Mid(keywordPivot(c.hi)).(keywordPivot)
+		if string(mid) <= c.lo || string(mid) >= c.hi {
+			t.Errorf("Mid(%q, %q) = %q, want a value strictly between them", c.lo, c.hi, string(mid))
+		}
+	}
+}
+
+func TestKeywordPivotMidEqual(t *testing.T) {
+	mid := keywordPivot("same").Mid(keywordPivot("same"))
+	if mid.(keywordPivot) != "same" {
+		t.Errorf("Mid of equal pivots = %q, want %q", string(mid.(keywordPivot)), "same")
+	}
+}
+
+func TestKeywordPivotMidNoStringBetween(t *testing.T) {
+	// "a\x00" is the immediate lexicographic successor of "a"; no string can sort strictly
+	// between them.
+	lo, hi := "a", "a\x00"
+	mid := keywordPivot(lo).Mid(keywordPivot(hi)).(keywordPivot)
+	if string(mid) != lo && string(mid) != hi {
+		t.Errorf("Mid(%q, %q) = %q, want one of the two inputs", lo, hi, string(mid))
+	}
+}
+
+func TestKeywordPivotMidMultipleTrailingZeros(t *testing.T) {
+	// Unlike "a"/"a\x00", a string does sort strictly between "a" and "a\x00\x00".
+	lo, hi := "a", "a\x00\x00"
+	mid := keywordPivot(lo).Mid(keywordPivot(hi)).(keywordPivot)
+	if string(mid) <= lo || string(mid) >= hi {
+		t.Errorf("Mid(%q, %q) = %q, want a value strictly between them", lo, hi, string(mid))
+	}
+}
+
+func TestKeywordPivotCmp(t *testing.T) {
+	if keywordPivot("a").Cmp(keywordPivot("b")) >= 0 {
+		t.Error("\"a\".Cmp(\"b\") should be negative")
+	}
+	if keywordPivot("b").Cmp(keywordPivot("a")) <= 0 {
+		t.Error("\"b\".Cmp(\"a\") should be positive")
+	}
+	if keywordPivot("a").Cmp(keywordPivot("a")) != 0 {
+		t.Error("\"a\".Cmp(\"a\") should be 0")
+	}
+}
+
+func TestLongPivotMid(t *testing.T) {
+	mid := longPivot(0).Mid(longPivot(10))
+	if mid.(longPivot) != 5 {
+		t.Errorf("Mid(0, 10) = %v, want 5", mid.(longPivot))
+	}
+}
+
+func TestReverseSort(t *testing.T) {
+	fields := []SortField{
+		{Field: "id", Type: Long, Asc: true},
+		{Field: "created_at", Type: Date, Asc: false},
+	}
+	reversed := reverseSort(fields)
+
+	if reversed[0].Asc != false || reversed[1].Asc != true {
+		t.Errorf("reverseSort did not flip directions: %+v", reversed)
+	}
+	if fields[0].Asc != true || fields[1].Asc != false {
+		t.Errorf("reverseSort mutated its input: %+v", fields)
+	}
+}
+
+func TestSortClause(t *testing.T) {
+	clause, err := sortClause([]SortField{{Field: "id", Type: Long, Asc: true}})
+	if err != nil {
+		t.Fatalf("sortClause returned error: %v", err)
+	}
+	want := `[{"id":{"order":"asc"}}]`
+	if clause != want {
+		t.Errorf("sortClause = %s, want %s", clause, want)
+	}
+}